@@ -0,0 +1,227 @@
+package timeseries
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RedisContext is the context-aware counterpart of Redis. Implementations
+// should respect ctx cancellation and deadlines for each call.
+type RedisContext interface {
+	Incr(ctx context.Context, key string) (int, error)
+	ZAdd(ctx context.Context, key string, score float64, member string) error
+	ZCount(ctx context.Context, key string, start interface{}, end interface{}) (int, error)
+	Expire(ctx context.Context, key string, seconds int) error
+}
+
+// ScripterContext is the context-aware counterpart of Scripter.
+type ScripterContext interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+	EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) (interface{}, error)
+	ScriptLoad(ctx context.Context, script string) (string, error)
+	ScriptExists(ctx context.Context, hashes ...string) ([]bool, error)
+}
+
+// noContextRedis adapts a Redis to RedisContext by ignoring ctx, so existing
+// backends keep working unchanged.
+type noContextRedis struct {
+	redis Redis
+}
+
+// noContextScripter additionally adapts a Scripter to ScripterContext by
+// ignoring ctx. It is only handed out by WithContext when redis actually
+// implements Scripter, so a type assertion against ScripterContext reflects
+// whether the wrapped backend really supports scripting.
+type noContextScripter struct {
+	noContextRedis
+	scripter Scripter
+}
+
+// WithContext adapts redis to RedisContext. Since the wrapped Redis has no
+// way to honor ctx, the returned implementation ignores it. If redis also
+// implements Scripter, the result implements ScripterContext too, so
+// TimeSeriesContext can still use the atomic script path from IncreaseAtTime.
+func WithContext(redis Redis) RedisContext {
+	base := noContextRedis{redis: redis}
+	if scripter, ok := redis.(Scripter); ok {
+		return &noContextScripter{noContextRedis: base, scripter: scripter}
+	}
+	return &base
+}
+
+func (r *noContextRedis) Incr(ctx context.Context, key string) (int, error) {
+	return r.redis.Incr(key)
+}
+
+func (r *noContextRedis) ZAdd(ctx context.Context, key string, score float64, member string) error {
+	return r.redis.ZAdd(key, score, member)
+}
+
+func (r *noContextRedis) ZCount(ctx context.Context, key string, start interface{}, end interface{}) (int, error) {
+	return r.redis.ZCount(key, start, end)
+}
+
+func (r *noContextRedis) Expire(ctx context.Context, key string, seconds int) error {
+	return r.redis.Expire(key, seconds)
+}
+
+func (r *noContextScripter) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return r.scripter.Eval(script, keys, args...)
+}
+
+func (r *noContextScripter) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) (interface{}, error) {
+	return r.scripter.EvalSha(sha1, keys, args...)
+}
+
+func (r *noContextScripter) ScriptLoad(ctx context.Context, script string) (string, error) {
+	return r.scripter.ScriptLoad(script)
+}
+
+func (r *noContextScripter) ScriptExists(ctx context.Context, hashes ...string) ([]bool, error) {
+	return r.scripter.ScriptExists(hashes...)
+}
+
+// TimeSeriesContext is the context-aware counterpart of TimeSeries. If
+// redis also implements ScripterContext, IncreaseAtTimeContext uses the
+// same atomic script path TimeSeries.IncreaseAtTime does; otherwise it
+// falls back to the per-command loop, with the same drift risk that
+// predates chunk0-1.
+type TimeSeriesContext struct {
+	redis    RedisContext
+	scripter ScripterContext
+
+	scriptOnce sync.Once
+	scriptSha  string
+
+	name       string
+	seriesKey  string
+	counterKey string
+
+	timestep time.Duration
+	ttl      time.Duration
+}
+
+func NewTimeSeriesContext(name string, timestep time.Duration, ttl time.Duration, redis RedisContext) *TimeSeriesContext {
+	seriesKey := fmt.Sprintf("%s:ts", name)
+	counterKey := fmt.Sprintf("%s:counter", name)
+	ts := &TimeSeriesContext{
+		redis:      redis,
+		name:       name,
+		seriesKey:  seriesKey,
+		counterKey: counterKey,
+		timestep:   timestep,
+		ttl:        ttl,
+	}
+	if scripter, ok := redis.(ScripterContext); ok {
+		ts.scripter = scripter
+	}
+	return ts
+}
+
+// IncreaseAtTimeContext adds amount at a specific time, aborting as soon as
+// ctx is done.
+//
+// If redis implements ScripterContext, this runs as a single atomic script
+// the same way TimeSeries.IncreaseAtTime does. Otherwise it falls back to
+// issuing the commands one by one (4*amount round trips, and it can drift
+// if the process dies mid-loop).
+func (ts *TimeSeriesContext) IncreaseAtTimeContext(ctx context.Context, amount int, t time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	counterKey := ts.makeKey(ts.counterKey, t)
+	seriesKey := ts.makeKey(ts.seriesKey, t)
+
+	if ts.scripter != nil {
+		return ts.increaseAtTimeScriptedContext(ctx, counterKey, seriesKey, amount, t)
+	}
+
+	for i := 0; i < amount; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		id, err := ts.redis.Incr(ctx, counterKey)
+		ts.redis.Expire(ctx, counterKey, int(ts.ttl.Seconds()))
+		if err != nil {
+			return err
+		}
+		err = ts.redis.ZAdd(
+			ctx,
+			seriesKey,
+			float64(t.Unix()),
+			fmt.Sprintf("%s:%d", ts.name, id),
+		)
+		if err != nil {
+			return err
+		}
+		ts.redis.Expire(ctx, seriesKey, int(ts.ttl.Seconds()))
+	}
+	return nil
+}
+
+// increaseAtTimeScriptedContext runs increaseScript via EVALSHA, loading it
+// on first use and falling back to EVAL if Redis has evicted it (NOSCRIPT).
+func (ts *TimeSeriesContext) increaseAtTimeScriptedContext(ctx context.Context, counterKey, seriesKey string, amount int, t time.Time) error {
+	keys := []string{counterKey, seriesKey}
+	args := []interface{}{t.Unix(), ts.name, int(ts.ttl.Seconds()), amount}
+
+	if sha := ts.getScriptSha(ctx); sha != "" {
+		_, err := ts.scripter.EvalSha(ctx, sha, keys, args...)
+		if err == nil {
+			return nil
+		}
+		if !isNoScriptErr(err) {
+			return err
+		}
+	}
+
+	_, err := ts.scripter.Eval(ctx, increaseScript, keys, args...)
+	return err
+}
+
+// getScriptSha loads increaseScript into Redis at most once per
+// TimeSeriesContext and returns the resulting SHA1, or "" if loading failed.
+func (ts *TimeSeriesContext) getScriptSha(ctx context.Context) string {
+	ts.scriptOnce.Do(func() {
+		sha, err := ts.scripter.ScriptLoad(ctx, increaseScript)
+		if err == nil {
+			ts.scriptSha = sha
+		}
+	})
+	return ts.scriptSha
+}
+
+// RangeContext returns the sum over the given range, aborting as soon as
+// ctx is done. Like Range, both ends are inclusive: members scored exactly
+// at end are counted. ErrBadRange is returned if start is after end.
+func (ts *TimeSeriesContext) RangeContext(ctx context.Context, start time.Time, end time.Time) (float64, error) {
+	if start.After(end) {
+		return 0, ErrBadRange
+	}
+	totalCount := 0
+	current := start.Truncate(ts.timestep)
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0.0, err
+		}
+		seriesKey := ts.makeKey(ts.seriesKey, current)
+		count, err := ts.redis.ZCount(ctx, seriesKey, start.Unix(), end.Unix())
+		if err != nil {
+			return 0.0, err
+		}
+		totalCount += count
+
+		current = current.Add(ts.timestep)
+		if current.After(end) {
+			break
+		}
+	}
+	return float64(totalCount), nil
+}
+
+func (ts *TimeSeriesContext) makeKey(prefix string, t time.Time) string {
+	return fmt.Sprintf("%s:%d", prefix, t.Truncate(ts.timestep).Unix())
+}
@@ -0,0 +1,53 @@
+package timeseries
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRangeDownsample(t *testing.T) {
+	redis := newFakeRedis()
+	ts := NewTimeSeries("test", time.Minute, time.Hour, redis)
+
+	base := time.Unix(0, 0)
+	counts := []int{1, 2, 3, 4, 5}
+	for i, n := range counts {
+		bucketTime := base.Add(time.Duration(i) * time.Minute)
+		if err := ts.IncreaseAtTime(n, bucketTime); err != nil {
+			t.Fatalf("IncreaseAtTime bucket %d: %v", i, err)
+		}
+	}
+
+	start := base
+	end := base.Add(time.Duration(len(counts)) * time.Minute)
+	step := 2 * time.Minute
+
+	buckets, err := ts.RangeDownsample(start, end, step, Sum)
+	if err != nil {
+		t.Fatalf("RangeDownsample: %v", err)
+	}
+
+	// [0,2) -> 1+2, [2,4) -> 3+4, [4,6) -> 5 (the last step is partial).
+	want := []float64{3, 7, 5}
+	if len(buckets) != len(want) {
+		t.Fatalf("got %d buckets, want %d: %+v", len(buckets), len(want), buckets)
+	}
+	for i, b := range buckets {
+		if b.Count != want[i] {
+			t.Errorf("bucket %d: got %v, want %v", i, b.Count, want[i])
+		}
+		if !b.Time.Equal(start.Add(time.Duration(i) * step)) {
+			t.Errorf("bucket %d: got time %v, want %v", i, b.Time, start.Add(time.Duration(i)*step))
+		}
+	}
+}
+
+func TestRangeDownsample_BadStep(t *testing.T) {
+	redis := newFakeRedis()
+	ts := NewTimeSeries("test", time.Minute, time.Hour, redis)
+
+	_, err := ts.RangeDownsample(time.Unix(0, 0), time.Unix(600, 0), 90*time.Second, Sum)
+	if err != ErrBadStep {
+		t.Fatalf("expected ErrBadStep, got %v", err)
+	}
+}
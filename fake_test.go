@@ -0,0 +1,202 @@
+package timeseries
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// fakeRedis is an in-memory stand-in for the Redis, Scripter, Pruner and
+// PFRedis interfaces, so tests don't need a real Redis server. EVAL/EVALSHA
+// run increaseScript's equivalent Go logic for whatever script they are
+// given, since increaseScript is the only script this package defines and
+// there is no Lua interpreter available here.
+type fakeRedis struct {
+	counters map[string]int
+	zsets    map[string]map[string]float64
+	ttls     map[string]int
+	hlls     map[string]map[string]struct{}
+
+	scripts map[string]string
+
+	evalCalls    int
+	evalShaCalls int
+	noScriptOnce bool // if true, the next EvalSha fails with NOSCRIPT
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		counters: make(map[string]int),
+		zsets:    make(map[string]map[string]float64),
+		ttls:     make(map[string]int),
+		hlls:     make(map[string]map[string]struct{}),
+		scripts:  make(map[string]string),
+	}
+}
+
+func (f *fakeRedis) Incr(key string) (int, error) {
+	f.counters[key]++
+	return f.counters[key], nil
+}
+
+func (f *fakeRedis) ZAdd(key string, score float64, member string) error {
+	if f.zsets[key] == nil {
+		f.zsets[key] = make(map[string]float64)
+	}
+	f.zsets[key][member] = score
+	return nil
+}
+
+func (f *fakeRedis) ZCount(key string, start interface{}, end interface{}) (int, error) {
+	lo, hi := toScore(start), toScore(end)
+	count := 0
+	for _, score := range f.zsets[key] {
+		if score >= lo && score <= hi {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeRedis) Expire(key string, seconds int) error {
+	f.ttls[key] = seconds
+	return nil
+}
+
+func (f *fakeRedis) ScriptLoad(script string) (string, error) {
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+	f.scripts[sha] = script
+	return sha, nil
+}
+
+func (f *fakeRedis) ScriptExists(hashes ...string) ([]bool, error) {
+	exists := make([]bool, len(hashes))
+	for i, h := range hashes {
+		_, exists[i] = f.scripts[h]
+	}
+	return exists, nil
+}
+
+func (f *fakeRedis) EvalSha(sha1 string, keys []string, args ...interface{}) (interface{}, error) {
+	f.evalShaCalls++
+	if f.noScriptOnce {
+		f.noScriptOnce = false
+		return nil, errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	if _, ok := f.scripts[sha1]; !ok {
+		return nil, errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return f.runIncreaseScript(keys, args)
+}
+
+func (f *fakeRedis) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	f.evalCalls++
+	return f.runIncreaseScript(keys, args)
+}
+
+// runIncreaseScript is increaseScript, the only script this package defines.
+func (f *fakeRedis) runIncreaseScript(keys []string, args []interface{}) (interface{}, error) {
+	counterKey, seriesKey := keys[0], keys[1]
+	score := toScore(args[0])
+	name, _ := args[1].(string)
+	ttl := toInt(args[2])
+	amount := toInt(args[3])
+
+	last := f.counters[counterKey] + amount
+	f.counters[counterKey] = last
+	first := last - amount + 1
+	for id := first; id <= last; id++ {
+		f.ZAdd(seriesKey, score, name+":"+strconv.Itoa(id))
+	}
+	f.ttls[counterKey] = ttl
+	f.ttls[seriesKey] = ttl
+	return int64(last), nil
+}
+
+func (f *fakeRedis) ZRemRangeByScore(key string, start interface{}, end interface{}) error {
+	lo, hi := toScore(start), toScore(end)
+	for member, score := range f.zsets[key] {
+		if score >= lo && score <= hi {
+			delete(f.zsets[key], member)
+		}
+	}
+	return nil
+}
+
+func (f *fakeRedis) Del(keys ...string) error {
+	for _, key := range keys {
+		delete(f.zsets, key)
+		delete(f.counters, key)
+		delete(f.ttls, key)
+	}
+	return nil
+}
+
+func (f *fakeRedis) Scan(pattern string) ([]string, error) {
+	var keys []string
+	for key := range f.zsets {
+		if ok, _ := filepath.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (f *fakeRedis) PFAdd(key string, members ...string) error {
+	if f.hlls[key] == nil {
+		f.hlls[key] = make(map[string]struct{})
+	}
+	for _, m := range members {
+		f.hlls[key][m] = struct{}{}
+	}
+	return nil
+}
+
+func (f *fakeRedis) PFCount(keys ...string) (int64, error) {
+	union := make(map[string]struct{})
+	for _, key := range keys {
+		for m := range f.hlls[key] {
+			union[m] = struct{}{}
+		}
+	}
+	return int64(len(union)), nil
+}
+
+func toScore(v interface{}) float64 {
+	switch x := v.(type) {
+	case int64:
+		return float64(x)
+	case int:
+		return float64(x)
+	case float64:
+		return x
+	case string:
+		switch x {
+		case "-inf":
+			return math.Inf(-1)
+		case "+inf":
+			return math.Inf(1)
+		}
+		f, _ := strconv.ParseFloat(x, 64)
+		return f
+	default:
+		return 0
+	}
+}
+
+func toInt(v interface{}) int {
+	switch x := v.(type) {
+	case int:
+		return x
+	case int64:
+		return int(x)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,165 @@
+package ratelimit
+
+import (
+	"errors"
+	"strconv"
+)
+
+// fakeRedis is a plain, non-scripting implementation of timeseries.Redis.
+// A Limiter built on it exercises Allow's fallback (Range + IncreaseAtTime)
+// path, since it doesn't implement timeseries.Scripter.
+type fakeRedis struct {
+	counters map[string]int
+	zsets    map[string]map[string]float64
+	ttls     map[string]int
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		counters: make(map[string]int),
+		zsets:    make(map[string]map[string]float64),
+		ttls:     make(map[string]int),
+	}
+}
+
+func (f *fakeRedis) Incr(key string) (int, error) {
+	f.counters[key]++
+	return f.counters[key], nil
+}
+
+func (f *fakeRedis) ZAdd(key string, score float64, member string) error {
+	if f.zsets[key] == nil {
+		f.zsets[key] = make(map[string]float64)
+	}
+	f.zsets[key][member] = score
+	return nil
+}
+
+func (f *fakeRedis) ZCount(key string, start interface{}, end interface{}) (int, error) {
+	lo, hi := toFloat(start), toFloat(end)
+	count := 0
+	for _, score := range f.zsets[key] {
+		if score >= lo && score <= hi {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeRedis) Expire(key string, seconds int) error {
+	f.ttls[key] = seconds
+	return nil
+}
+
+func toFloat(v interface{}) float64 {
+	switch x := v.(type) {
+	case int64:
+		return float64(x)
+	case int:
+		return float64(x)
+	case float64:
+		return x
+	default:
+		return 0
+	}
+}
+
+// fakeScriptedRedis additionally implements timeseries.Scripter, so a
+// Limiter built on it exercises Allow's atomic allowScript path. EVAL and
+// EVALSHA are emulated by recognizing allowScript's body and running the
+// equivalent Go logic, since there is no Lua interpreter available here.
+type fakeScriptedRedis struct {
+	*fakeRedis
+
+	scripts      map[string]string
+	evalCalls    int
+	evalShaCalls int
+	noScriptOnce bool
+}
+
+func newFakeScriptedRedis() *fakeScriptedRedis {
+	return &fakeScriptedRedis{
+		fakeRedis: newFakeRedis(),
+		scripts:   make(map[string]string),
+	}
+}
+
+func (f *fakeScriptedRedis) ScriptLoad(script string) (string, error) {
+	sha := "sha-" + script[:8]
+	f.scripts[sha] = script
+	return sha, nil
+}
+
+func (f *fakeScriptedRedis) ScriptExists(hashes ...string) ([]bool, error) {
+	exists := make([]bool, len(hashes))
+	for i, h := range hashes {
+		_, exists[i] = f.scripts[h]
+	}
+	return exists, nil
+}
+
+func (f *fakeScriptedRedis) EvalSha(sha1 string, keys []string, args ...interface{}) (interface{}, error) {
+	f.evalShaCalls++
+	if f.noScriptOnce {
+		f.noScriptOnce = false
+		return nil, errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	script, ok := f.scripts[sha1]
+	if !ok {
+		return nil, errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return f.run(script, keys, args)
+}
+
+func (f *fakeScriptedRedis) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	f.evalCalls++
+	return f.run(script, keys, args)
+}
+
+// run dispatches on the script text itself, not just the call site, since
+// this same fake also backs the TimeSeries a Limiter creates internally —
+// under WithSlidingWindow, Allow falls through to ts.IncreaseAtTime, which
+// sends timeseries's own increaseScript through this same Scripter. Treating
+// every Eval/EvalSha as allowScript would silently corrupt that call.
+func (f *fakeScriptedRedis) run(script string, keys []string, args []interface{}) (interface{}, error) {
+	if script == allowScript {
+		return f.runAllowScript(keys, args)
+	}
+	return f.runIncreaseScript(keys, args)
+}
+
+// runIncreaseScript mirrors timeseries's increaseScript: KEYS are
+// [counterKey, seriesKey], ARGV is [score, name, ttl, amount].
+func (f *fakeScriptedRedis) runIncreaseScript(keys []string, args []interface{}) (interface{}, error) {
+	counterKey, seriesKey := keys[0], keys[1]
+	score := toFloat(args[0])
+	name, _ := args[1].(string)
+	ttl := int(toFloat(args[2]))
+	amount := int(toFloat(args[3]))
+
+	last := f.counters[counterKey] + amount
+	f.counters[counterKey] = last
+	for id := last - amount + 1; id <= last; id++ {
+		f.ZAdd(seriesKey, score, name+":"+strconv.Itoa(id))
+	}
+	f.Expire(counterKey, ttl)
+	f.Expire(seriesKey, ttl)
+	return int64(last), nil
+}
+
+// runAllowScript is allowScript.
+func (f *fakeScriptedRedis) runAllowScript(keys []string, args []interface{}) (interface{}, error) {
+	seriesKey, counterKey := keys[0], keys[1]
+	rangeStart, rangeEnd := args[0], args[1]
+	rate := toFloat(args[2])
+	ttl := int(toFloat(args[3]))
+
+	count, _ := f.ZCount(seriesKey, rangeStart, rangeEnd)
+	if float64(count) < rate {
+		id, _ := f.Incr(counterKey)
+		f.ZAdd(seriesKey, toFloat(rangeEnd), strconv.Itoa(id))
+		f.Expire(seriesKey, ttl)
+		f.Expire(counterKey, ttl)
+	}
+	return int64(count), nil
+}
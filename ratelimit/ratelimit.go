@@ -0,0 +1,241 @@
+// Package ratelimit implements a sliding-window rate limiter on top of
+// TimeSeries, in the spirit of github.com/go-redis/redis_rate.
+package ratelimit
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	timeseries "github.com/codesuki/go-redis-time-series"
+)
+
+// Result describes the outcome of a Limiter.Allow check.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+// Option configures a Limiter.
+type Option func(*Limiter)
+
+// WithSlidingWindow weights the previous bucket's count by how much of it
+// is still "in view" (1 - elapsed/per) and adds it to the current bucket's
+// count, instead of only counting the current fixed window. This smooths
+// the bursts a plain fixed-window counter allows at bucket boundaries, at
+// the cost of not being usable with the atomic check-and-increment script
+// (see Allow).
+func WithSlidingWindow() Option {
+	return func(l *Limiter) {
+		l.sliding = true
+	}
+}
+
+// Limiter is a per-key rate limiter backed by TimeSeries.
+type Limiter struct {
+	redis   timeseries.Redis
+	sliding bool
+
+	mu     sync.Mutex
+	series map[string]*timeseries.TimeSeries
+
+	allowScriptOnce sync.Once
+	allowScriptSha  string
+}
+
+// NewLimiter creates a Limiter backed by redis.
+func NewLimiter(redis timeseries.Redis, opts ...Option) *Limiter {
+	l := &Limiter{redis: redis}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Allow reports whether a request for key is allowed under a limit of rate
+// requests per duration per, and records the request if it is allowed.
+//
+// If redis implements timeseries.Scripter and the limiter is not using
+// WithSlidingWindow, the check and the increment run as a single atomic
+// script (ZCOUNT of the current bucket followed by a conditional ZADD),
+// closing the race where two concurrent callers both observe count < rate
+// and both get admitted. The sliding-window variant always needs two round
+// trips, since it reads two buckets.
+func (l *Limiter) Allow(key string, rate int, per time.Duration) (*Result, error) {
+	now := time.Now()
+
+	ttl := per
+	if l.sliding {
+		// slidingCount reads the previous bucket from inside the current
+		// window, so that key must outlive a full extra per or a
+		// low-traffic key's smoothing silently degrades to fixed-window.
+		ttl = 2 * per
+	}
+	ts := l.timeSeriesFor(key, per, ttl)
+
+	bucketStart := now.Truncate(per)
+	resetAfter := bucketStart.Add(per).Sub(now)
+
+	if !l.sliding {
+		if scripter, ok := l.redis.(timeseries.Scripter); ok {
+			return l.allowScripted(scripter, ts.SeriesKey(now), ts.CounterKey(now), now, rate, per, resetAfter)
+		}
+	}
+
+	var count float64
+	var err error
+	if l.sliding {
+		count, err = l.slidingCount(ts, bucketStart, now, per)
+	} else {
+		count, err = ts.Range(bucketStart, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if int(count) < rate {
+		if err := ts.IncreaseAtTime(1, now); err != nil {
+			return nil, err
+		}
+		return &Result{
+			Allowed:    true,
+			Remaining:  rate - int(count) - 1,
+			ResetAfter: resetAfter,
+		}, nil
+	}
+
+	return &Result{
+		Allowed:    false,
+		RetryAfter: resetAfter,
+		ResetAfter: resetAfter,
+	}, nil
+}
+
+// timeSeriesFor returns the TimeSeries for key, per and ttl, reusing it
+// across calls so its Scripter script-SHA cache isn't reloaded on every
+// Allow.
+func (l *Limiter) timeSeriesFor(key string, per, ttl time.Duration) *timeseries.TimeSeries {
+	cacheKey := fmt.Sprintf("%s:%d:%d", key, per, ttl)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.series == nil {
+		l.series = make(map[string]*timeseries.TimeSeries)
+	}
+	ts, ok := l.series[cacheKey]
+	if !ok {
+		ts = timeseries.NewTimeSeries(key, per, ttl, l.redis)
+		l.series[cacheKey] = ts
+	}
+	return ts
+}
+
+// slidingCount approximates the count over the trailing window ending at
+// now by weighting the previous bucket's exact count by the fraction of it
+// still within the window and adding the current bucket's exact count.
+func (l *Limiter) slidingCount(ts *timeseries.TimeSeries, bucketStart, now time.Time, per time.Duration) (float64, error) {
+	previousStart := bucketStart.Add(-per)
+	previousBuckets, err := ts.RangeBuckets(previousStart, bucketStart)
+	if err != nil {
+		return 0, err
+	}
+	var previousCount float64
+	if len(previousBuckets) > 0 {
+		previousCount = previousBuckets[0].Count
+	}
+	currentCount, err := ts.Range(bucketStart, now)
+	if err != nil {
+		return 0, err
+	}
+
+	elapsed := now.Sub(bucketStart)
+	weight := 1 - float64(elapsed)/float64(per)
+	return weight*previousCount + currentCount, nil
+}
+
+// allowScript atomically counts the members already in the current bucket
+// and, if that is below the limit, mints a fresh member by INCRing the
+// bucket's counter key and adds it. KEYS[1] is the bucket's sorted set
+// key, KEYS[2] its counter key (the same two keys TimeSeries.IncreaseAtTime
+// uses, so the minted member can never collide with another caller's,
+// unlike a client-supplied timestamp). ARGV: window start score (now - per),
+// now score, rate limit, ttl seconds.
+const allowScript = `
+local count = redis.call('ZCOUNT', KEYS[1], ARGV[1], ARGV[2])
+if count < tonumber(ARGV[3]) then
+	local id = redis.call('INCR', KEYS[2])
+	redis.call('ZADD', KEYS[1], ARGV[2], id)
+	redis.call('EXPIRE', KEYS[1], ARGV[4])
+	redis.call('EXPIRE', KEYS[2], ARGV[4])
+end
+return count
+`
+
+func (l *Limiter) allowScripted(scripter timeseries.Scripter, seriesKey, counterKey string, now time.Time, rate int, per time.Duration, resetAfter time.Duration) (*Result, error) {
+	keys := []string{seriesKey, counterKey}
+	args := []interface{}{
+		now.Add(-per).Unix(),
+		now.Unix(),
+		rate,
+		int(per.Seconds()),
+	}
+
+	reply, err := l.evalAllowScript(scripter, keys, args)
+	if err != nil {
+		return nil, err
+	}
+
+	count := toInt(reply)
+	if count < rate {
+		return &Result{
+			Allowed:    true,
+			Remaining:  rate - count - 1,
+			ResetAfter: resetAfter,
+		}, nil
+	}
+	return &Result{
+		Allowed:    false,
+		RetryAfter: resetAfter,
+		ResetAfter: resetAfter,
+	}, nil
+}
+
+// evalAllowScript runs allowScript via EVALSHA, loading it at most once per
+// Limiter and falling back to EVAL if Redis has evicted it (NOSCRIPT).
+func (l *Limiter) evalAllowScript(scripter timeseries.Scripter, keys []string, args []interface{}) (interface{}, error) {
+	if sha := l.getAllowScriptSha(scripter); sha != "" {
+		reply, err := scripter.EvalSha(sha, keys, args...)
+		if err == nil || !isNoScriptErr(err) {
+			return reply, err
+		}
+	}
+	return scripter.Eval(allowScript, keys, args...)
+}
+
+func (l *Limiter) getAllowScriptSha(scripter timeseries.Scripter) string {
+	l.allowScriptOnce.Do(func() {
+		sha, err := scripter.ScriptLoad(allowScript)
+		if err == nil {
+			l.allowScriptSha = sha
+		}
+	})
+	return l.allowScriptSha
+}
+
+func isNoScriptErr(err error) bool {
+	return strings.Contains(err.Error(), "NOSCRIPT")
+}
+
+func toInt(reply interface{}) int {
+	switch v := reply.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	default:
+		return 0
+	}
+}
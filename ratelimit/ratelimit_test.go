@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow_Scripted(t *testing.T) {
+	redis := newFakeScriptedRedis()
+	l := NewLimiter(redis)
+
+	for i := 0; i < 3; i++ {
+		result, err := l.Allow("key", 3, time.Minute)
+		if err != nil {
+			t.Fatalf("Allow %d: %v", i, err)
+		}
+		if !result.Allowed {
+			t.Fatalf("Allow %d: expected allowed, got denied", i)
+		}
+	}
+
+	result, err := l.Allow("key", 3, time.Minute)
+	if err != nil {
+		t.Fatalf("Allow over limit: %v", err)
+	}
+	if result.Allowed {
+		t.Fatalf("expected the 4th request over a limit of 3 to be denied")
+	}
+
+	if redis.evalShaCalls == 0 {
+		t.Fatalf("expected Allow to use the scripted path, got evalShaCalls=%d evalCalls=%d", redis.evalShaCalls, redis.evalCalls)
+	}
+}
+
+func TestAllow_FallbackWithoutScripter(t *testing.T) {
+	redis := newFakeRedis()
+	l := NewLimiter(redis)
+
+	for i := 0; i < 3; i++ {
+		result, err := l.Allow("key", 3, time.Minute)
+		if err != nil {
+			t.Fatalf("Allow %d: %v", i, err)
+		}
+		if !result.Allowed {
+			t.Fatalf("Allow %d: expected allowed, got denied", i)
+		}
+	}
+
+	result, err := l.Allow("key", 3, time.Minute)
+	if err != nil {
+		t.Fatalf("Allow over limit: %v", err)
+	}
+	if result.Allowed {
+		t.Fatalf("expected the 4th request over a limit of 3 to be denied")
+	}
+}
+
+func TestAllow_ScriptedFallsBackOnNoScript(t *testing.T) {
+	redis := newFakeScriptedRedis()
+	l := NewLimiter(redis)
+
+	if _, err := l.Allow("key", 3, time.Minute); err != nil {
+		t.Fatalf("first Allow: %v", err)
+	}
+	if redis.evalShaCalls != 1 || redis.evalCalls != 0 {
+		t.Fatalf("expected first Allow to use EVALSHA only, got evalSha=%d eval=%d", redis.evalShaCalls, redis.evalCalls)
+	}
+
+	// Simulate Redis having evicted allowScript from its cache.
+	redis.noScriptOnce = true
+	result, err := l.Allow("key", 3, time.Minute)
+	if err != nil {
+		t.Fatalf("second Allow: %v", err)
+	}
+	if !result.Allowed {
+		t.Fatalf("expected second Allow to still be allowed after the NOSCRIPT fallback")
+	}
+	if redis.evalShaCalls != 2 || redis.evalCalls != 1 {
+		t.Fatalf("expected NOSCRIPT to fall back to EVAL, got evalSha=%d eval=%d", redis.evalShaCalls, redis.evalCalls)
+	}
+}
+
+func TestAllow_SlidingNeverScripted(t *testing.T) {
+	// WithSlidingWindow always takes the two-round-trip path, even when the
+	// underlying redis implements Scripter, since it reads two buckets.
+	// allowScriptSha is only ever populated by evalAllowScript, so its
+	// absence shows Allow never reached the scripted branch (TimeSeries's
+	// own, unrelated script for IncreaseAtTime may still fire).
+	redis := newFakeScriptedRedis()
+	l := NewLimiter(redis, WithSlidingWindow())
+
+	if _, err := l.Allow("key", 3, time.Minute); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+
+	if l.allowScriptSha != "" {
+		t.Fatalf("expected sliding window to skip the allowScript path, got allowScriptSha=%q", l.allowScriptSha)
+	}
+}
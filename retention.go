@@ -0,0 +1,110 @@
+package timeseries
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clock abstracts time.Now so StartRetention and Purge's notion of "now"
+// can be controlled in tests. See WithClock.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Pruner is implemented by Redis clients that support finding keys by
+// pattern, trimming sorted set members below a score, and deleting keys
+// outright.
+type Pruner interface {
+	Scan(pattern string) ([]string, error)
+	ZRemRangeByScore(key string, start interface{}, end interface{}) error
+	Del(keys ...string) error
+}
+
+// StartRetention runs Purge(ts.clock.Now()) every interval in a background
+// goroutine until ctx is done. It returns immediately and does nothing if
+// interval is not positive. Purge errors are swallowed, since this is a
+// best-effort sweep for data Redis's own TTL should already be clearing.
+func (ts *TimeSeries) StartRetention(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ts.Purge(ts.clock.Now())
+			}
+		}
+	}()
+}
+
+// Purge removes bucket data Redis's own key TTL might have missed, e.g.
+// because a later write to the same bucket extended its TTL, or because
+// IncreaseAtTime's counter keys never have members trimmed from them. It
+// scans every existing series key rather than assuming a fixed number of
+// buckets have aged out since the last call, so it still finds a bucket
+// whose TTL was refreshed by a backfill write, and it works regardless of
+// how StartRetention's interval relates to ts.timestep or how long it has
+// been since Purge last ran. Buckets entirely before before.Add(-ts.ttl)
+// are deleted outright with DEL; the one straddling that cutoff is trimmed
+// with ZREMRANGEBYSCORE instead. Purge is a no-op if redis does not
+// implement Pruner.
+func (ts *TimeSeries) Purge(before time.Time) error {
+	if ts.pruner == nil {
+		return nil
+	}
+	cutoff := before.Add(-ts.ttl)
+
+	seriesKeys, err := ts.pruner.Scan(ts.seriesKey + ":*")
+	if err != nil {
+		return err
+	}
+
+	for _, seriesKey := range seriesKeys {
+		bucketTime, ok := parseBucketTime(ts.seriesKey, seriesKey)
+		if !ok {
+			continue
+		}
+
+		if !bucketTime.Add(ts.timestep).After(cutoff) {
+			counterKey := ts.makeKey(ts.counterKey, bucketTime)
+			if err := ts.pruner.Del(seriesKey, counterKey); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if bucketTime.Before(cutoff) {
+			if err := ts.pruner.ZRemRangeByScore(seriesKey, "-inf", cutoff.Unix()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseBucketTime recovers the bucket time encoded in a key makeKey(prefix, t)
+// would have produced, reporting ok=false for any key that doesn't match.
+func parseBucketTime(prefix, key string) (time.Time, bool) {
+	suffix := strings.TrimPrefix(key, prefix+":")
+	if suffix == key {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(suffix, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
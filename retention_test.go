@@ -0,0 +1,90 @@
+package timeseries
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPurge(t *testing.T) {
+	redis := newFakeRedis()
+	ts := NewTimeSeries("test", time.Minute, 5*time.Minute, redis)
+
+	// Pick a before that doesn't land exactly on a minute boundary, so the
+	// cutoff falls inside a bucket instead of exactly on its edge.
+	before := time.Unix(3630, 0)
+	cutoff := before.Add(-ts.ttl) // 3330
+
+	// Fully expired: the whole bucket is before cutoff, so Purge should
+	// delete its keys outright.
+	expired := cutoff.Add(-2 * time.Minute).Truncate(ts.timestep) // 3180
+	expiredSeriesKey := ts.makeKey(ts.seriesKey, expired)
+	expiredCounterKey := ts.makeKey(ts.counterKey, expired)
+	redis.ZAdd(expiredSeriesKey, float64(expired.Unix()), "test:1")
+	redis.Incr(expiredCounterKey)
+
+	// Straddling: cutoff falls inside this bucket's timestep window, so
+	// Purge should trim only the members older than cutoff.
+	straddle := cutoff.Truncate(ts.timestep) // 3300
+	straddleSeriesKey := ts.makeKey(ts.seriesKey, straddle)
+	redis.ZAdd(straddleSeriesKey, float64(straddle.Unix()), "test:2-old")
+	redis.ZAdd(straddleSeriesKey, float64(cutoff.Add(30*time.Second).Unix()), "test:2-new")
+
+	// Fresh: entirely after cutoff, must survive untouched.
+	fresh := cutoff.Add(2 * time.Minute).Truncate(ts.timestep) // 3420
+	freshSeriesKey := ts.makeKey(ts.seriesKey, fresh)
+	redis.ZAdd(freshSeriesKey, float64(fresh.Unix()), "test:3")
+
+	if err := ts.Purge(before); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+
+	if _, ok := redis.zsets[expiredSeriesKey]; ok {
+		t.Errorf("expired bucket's series key should have been deleted")
+	}
+	if _, ok := redis.counters[expiredCounterKey]; ok {
+		t.Errorf("expired bucket's counter key should have been deleted")
+	}
+
+	straddleMembers := redis.zsets[straddleSeriesKey]
+	if _, ok := straddleMembers["test:2-old"]; ok {
+		t.Errorf("straddling bucket's stale member should have been trimmed")
+	}
+	if _, ok := straddleMembers["test:2-new"]; !ok {
+		t.Errorf("straddling bucket's fresh member should have survived")
+	}
+
+	freshMembers := redis.zsets[freshSeriesKey]
+	if _, ok := freshMembers["test:3"]; !ok {
+		t.Errorf("fresh bucket should be untouched")
+	}
+}
+
+func TestPurge_NoPruner(t *testing.T) {
+	ts := NewTimeSeries("test", time.Minute, 5*time.Minute, &noPrunerRedis{redis: newFakeRedis()})
+	if err := ts.Purge(time.Unix(3600, 0)); err != nil {
+		t.Fatalf("Purge with no Pruner should be a no-op, got: %v", err)
+	}
+}
+
+// noPrunerRedis implements only Redis, not Pruner, by forwarding to a
+// fakeRedis through the Redis interface rather than embedding its concrete
+// type (which would promote its Pruner methods too).
+type noPrunerRedis struct {
+	redis Redis
+}
+
+func (r *noPrunerRedis) Incr(key string) (int, error) {
+	return r.redis.Incr(key)
+}
+
+func (r *noPrunerRedis) ZAdd(key string, score float64, member string) error {
+	return r.redis.ZAdd(key, score, member)
+}
+
+func (r *noPrunerRedis) ZCount(key string, start interface{}, end interface{}) (int, error) {
+	return r.redis.ZCount(key, start, end)
+}
+
+func (r *noPrunerRedis) Expire(key string, seconds int) error {
+	return r.redis.Expire(key, seconds)
+}
@@ -3,6 +3,8 @@ package timeseries
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,9 +20,40 @@ type Redis interface {
 	Expire(key string, seconds int) error
 }
 
-// TODO: add clock parameter
+// Scripter is implemented by Redis clients that support server-side Lua
+// scripting (e.g. go-redis). When the Redis backend passed to NewTimeSeries
+// also implements Scripter, TimeSeries performs IncreaseAtTime as a single
+// atomic round trip instead of falling back to the per-command path.
+type Scripter interface {
+	Eval(script string, keys []string, args ...interface{}) (interface{}, error)
+	EvalSha(sha1 string, keys []string, args ...interface{}) (interface{}, error)
+	ScriptLoad(script string) (string, error)
+	ScriptExists(hashes ...string) ([]bool, error)
+}
+
+// increaseScript atomically increments the counter key by the given amount,
+// adds one sorted set member per incremented id, and refreshes the TTL on
+// both keys. KEYS: counterKey, seriesKey. ARGV: timestamp, name, ttl seconds, amount.
+const increaseScript = `
+local amount = tonumber(ARGV[4])
+local last = redis.call('INCRBY', KEYS[1], amount)
+local first = last - amount + 1
+for id = first, last do
+	redis.call('ZADD', KEYS[2], ARGV[1], ARGV[2] .. ':' .. id)
+end
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+return last
+`
+
 type TimeSeries struct {
-	redis Redis
+	redis    Redis
+	scripter Scripter
+	pruner   Pruner
+	clock    Clock
+
+	scriptOnce sync.Once
+	scriptSha  string
 
 	name       string
 	seriesKey  string
@@ -30,29 +63,61 @@ type TimeSeries struct {
 	ttl      time.Duration
 }
 
-func NewTimeSeries(name string, timestep time.Duration, ttl time.Duration, redis Redis) *TimeSeries {
+// Option configures a TimeSeries created by NewTimeSeries.
+type Option func(*TimeSeries)
+
+// WithClock overrides the Clock a TimeSeries uses for StartRetention and
+// Purge's notion of "now". It defaults to the real wall clock.
+func WithClock(clock Clock) Option {
+	return func(ts *TimeSeries) {
+		ts.clock = clock
+	}
+}
+
+func NewTimeSeries(name string, timestep time.Duration, ttl time.Duration, redis Redis, opts ...Option) *TimeSeries {
 	seriesKey := fmt.Sprintf("%s:ts", name)
 	counterKey := fmt.Sprintf("%s:counter", name)
-	return &TimeSeries{
+	ts := &TimeSeries{
 		redis:      redis,
+		clock:      realClock{},
 		name:       name,
 		seriesKey:  seriesKey,
 		counterKey: counterKey,
 		timestep:   timestep,
 		ttl:        ttl,
 	}
+	if scripter, ok := redis.(Scripter); ok {
+		ts.scripter = scripter
+	}
+	if pruner, ok := redis.(Pruner); ok {
+		ts.pruner = pruner
+	}
+	for _, opt := range opts {
+		opt(ts)
+	}
+	return ts
 }
 
 // IncreaseAtTime adds amount at a specific time.
+//
+// If the underlying Redis implements Scripter, the increment, the sorted
+// set inserts and both TTL refreshes happen atomically in a single round
+// trip. Otherwise it falls back to issuing the commands one by one, which
+// takes 4*amount round trips and can drift if the process dies mid-loop.
 func (ts *TimeSeries) IncreaseAtTime(amount int, t time.Time) error {
+	counterKey := ts.makeKey(ts.counterKey, t)
+	seriesKey := ts.makeKey(ts.seriesKey, t)
+
+	if ts.scripter != nil {
+		return ts.increaseAtTimeScripted(counterKey, seriesKey, amount, t)
+	}
+
 	for i := 0; i < amount; i++ {
-		counterKey := ts.makeKey(ts.counterKey, t)
 		id, err := ts.redis.Incr(counterKey)
 		ts.redis.Expire(counterKey, int(ts.ttl.Seconds()))
 		if err != nil {
 			return err
 		}
-		seriesKey := ts.makeKey(ts.seriesKey, t)
 		err = ts.redis.ZAdd(
 			seriesKey,
 			float64(t.Unix()),
@@ -66,6 +131,42 @@ func (ts *TimeSeries) IncreaseAtTime(amount int, t time.Time) error {
 	return nil
 }
 
+// increaseAtTimeScripted runs increaseScript via EVALSHA, loading it on
+// first use and falling back to EVAL if Redis has evicted it (NOSCRIPT).
+func (ts *TimeSeries) increaseAtTimeScripted(counterKey, seriesKey string, amount int, t time.Time) error {
+	keys := []string{counterKey, seriesKey}
+	args := []interface{}{t.Unix(), ts.name, int(ts.ttl.Seconds()), amount}
+
+	if sha := ts.getScriptSha(); sha != "" {
+		_, err := ts.scripter.EvalSha(sha, keys, args...)
+		if err == nil {
+			return nil
+		}
+		if !isNoScriptErr(err) {
+			return err
+		}
+	}
+
+	_, err := ts.scripter.Eval(increaseScript, keys, args...)
+	return err
+}
+
+// getScriptSha loads increaseScript into Redis at most once per TimeSeries
+// and returns the resulting SHA1, or "" if loading failed.
+func (ts *TimeSeries) getScriptSha() string {
+	ts.scriptOnce.Do(func() {
+		sha, err := ts.scripter.ScriptLoad(increaseScript)
+		if err == nil {
+			ts.scriptSha = sha
+		}
+	})
+	return ts.scriptSha
+}
+
+func isNoScriptErr(err error) bool {
+	return strings.Contains(err.Error(), "NOSCRIPT")
+}
+
 // Range returns the sum over the given range [start, end).
 // ErrBadRange is returned if start is after end.
 func (ts *TimeSeries) Range(start time.Time, end time.Time) (float64, error) {
@@ -91,6 +192,141 @@ func (ts *TimeSeries) Range(start time.Time, end time.Time) (float64, error) {
 	return float64(totalCount), nil
 }
 
+// Bucket is one timestep-wide sample returned by RangeBuckets and
+// RangeDownsample.
+type Bucket struct {
+	Time  time.Time
+	Count float64
+}
+
+// AggFunc aggregates the Count values of the buckets making up one
+// RangeDownsample output bucket. Sum, Avg, Min, Max and Count are the
+// built-in AggFuncs.
+type AggFunc func(counts []float64) float64
+
+// Sum adds the counts together.
+func Sum(counts []float64) float64 {
+	var sum float64
+	for _, c := range counts {
+		sum += c
+	}
+	return sum
+}
+
+// Avg returns the mean of the counts, or 0 if there are none.
+func Avg(counts []float64) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+	return Sum(counts) / float64(len(counts))
+}
+
+// Min returns the smallest count, or 0 if there are none.
+func Min(counts []float64) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+	min := counts[0]
+	for _, c := range counts[1:] {
+		if c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// Max returns the largest count, or 0 if there are none.
+func Max(counts []float64) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+	max := counts[0]
+	for _, c := range counts[1:] {
+		if c > max {
+			max = c
+		}
+	}
+	return max
+}
+
+// Count returns the number of buckets, ignoring their values.
+func Count(counts []float64) float64 {
+	return float64(len(counts))
+}
+
+// ErrBadStep indicates that step is not a positive multiple of the
+// TimeSeries' timestep.
+var ErrBadStep = errors.New("timeseries: step must be a multiple of the series timestep")
+
+// RangeBuckets returns one Bucket per ts.timestep step in [start, end),
+// preserving the per-bucket structure that Range collapses into a single sum.
+func (ts *TimeSeries) RangeBuckets(start time.Time, end time.Time) ([]Bucket, error) {
+	if start.After(end) {
+		return nil, ErrBadRange
+	}
+	var buckets []Bucket
+	current := start.Truncate(ts.timestep)
+	for current.Before(end) {
+		seriesKey := ts.makeKey(ts.seriesKey, current)
+		count, err := ts.redis.ZCount(seriesKey, current.Unix(), current.Add(ts.timestep).Unix())
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, Bucket{Time: current, Count: float64(count)})
+		current = current.Add(ts.timestep)
+	}
+	return buckets, nil
+}
+
+// RangeDownsample returns one Bucket per step in [start, end), each
+// aggregating the ts.timestep buckets it covers with agg. step must be a
+// positive multiple of ts.timestep.
+func (ts *TimeSeries) RangeDownsample(start time.Time, end time.Time, step time.Duration, agg AggFunc) ([]Bucket, error) {
+	if start.After(end) {
+		return nil, ErrBadRange
+	}
+	if step <= 0 || step%ts.timestep != 0 {
+		return nil, ErrBadStep
+	}
+
+	buckets, err := ts.RangeBuckets(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var downsampled []Bucket
+	current := start.Truncate(step)
+	for current.Before(end) {
+		next := current.Add(step)
+		var counts []float64
+		for _, b := range buckets {
+			if !b.Time.Before(current) && b.Time.Before(next) {
+				counts = append(counts, b.Count)
+			}
+		}
+		downsampled = append(downsampled, Bucket{Time: current, Count: agg(counts)})
+		current = next
+	}
+	return downsampled, nil
+}
+
 func (ts *TimeSeries) makeKey(prefix string, t time.Time) string {
 	return fmt.Sprintf("%s:%d", prefix, t.Truncate(ts.timestep).Unix())
 }
+
+// SeriesKey returns the sorted set key holding the bucket t falls into.
+// It is exported so packages built on top of TimeSeries (e.g. ratelimit)
+// can run their own Redis commands, including Lua scripts, against the
+// same bucket TimeSeries itself would use for t.
+func (ts *TimeSeries) SeriesKey(t time.Time) string {
+	return ts.makeKey(ts.seriesKey, t)
+}
+
+// CounterKey returns the INCR counter key for the bucket t falls into. It
+// is exported for the same reason as SeriesKey: so a caller writing its
+// own script against this TimeSeries's bucket can mint a unique ZSET
+// member the same way increaseScript does, instead of relying on
+// client-supplied values that can collide.
+func (ts *TimeSeries) CounterKey(t time.Time) string {
+	return ts.makeKey(ts.counterKey, t)
+}
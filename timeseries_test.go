@@ -0,0 +1,36 @@
+package timeseries
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIncreaseAtTime_ScriptedFallbackToEval(t *testing.T) {
+	redis := newFakeRedis()
+	ts := NewTimeSeries("test", time.Minute, time.Hour, redis)
+	now := time.Unix(1000, 0)
+
+	if err := ts.IncreaseAtTime(1, now); err != nil {
+		t.Fatalf("first IncreaseAtTime: %v", err)
+	}
+	if redis.evalShaCalls != 1 || redis.evalCalls != 0 {
+		t.Fatalf("expected first call to use EVALSHA only, got evalSha=%d eval=%d", redis.evalShaCalls, redis.evalCalls)
+	}
+
+	// Simulate Redis having evicted the script from its cache.
+	redis.noScriptOnce = true
+	if err := ts.IncreaseAtTime(1, now); err != nil {
+		t.Fatalf("second IncreaseAtTime: %v", err)
+	}
+	if redis.evalShaCalls != 2 || redis.evalCalls != 1 {
+		t.Fatalf("expected NOSCRIPT to fall back to EVAL, got evalSha=%d eval=%d", redis.evalShaCalls, redis.evalCalls)
+	}
+
+	count, err := ts.Range(now, now)
+	if err != nil {
+		t.Fatalf("Range: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 increments recorded, got %v", count)
+	}
+}